@@ -0,0 +1,142 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	apiregv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+	aggregatorclient "k8s.io/kube-aggregator/pkg/client/clientset_generated/clientset"
+)
+
+const apiServiceRegistrationHookName = "apiservice-registration"
+
+// WithAPIServiceRegistration registers a post-start hook that creates or updates an APIService in the host
+// cluster for every GroupVersion this Server serves, pointing the aggregator at serviceRef and validating
+// the connection with caBundle, and a pre-shutdown hook that removes them again. Without this, the operator
+// must apply matching APIService objects by hand (e.g. via `kubectl apply`) after deploying the apiserver.
+//
+// Each APIService's GroupPriorityMinimum and VersionPriority come from WithGroupPriorityMinimum and
+// WithVersionPriority; call those first if the defaults aren't right for this API.
+//
+// See WithAPIServiceCABundlePath to read a rotating CA bundle from disk instead of passing a fixed one here.
+func (a *Server) WithAPIServiceRegistration(serviceRef apiregv1.ServiceReference, caBundle []byte) *Server {
+	a.apiServiceRegistrationEnabled = true
+	a.apiServiceRef = serviceRef
+	a.apiServiceCABundle = caBundle
+	return a
+}
+
+// WithAPIServiceCABundlePath configures APIService registration (see WithAPIServiceRegistration) to read
+// the CA bundle from path -- typically a projected secret volume -- immediately before each create/update,
+// instead of using a CA bundle baked in at Build() time. This lets the bundle rotate without a restart.
+func (a *Server) WithAPIServiceCABundlePath(path string) *Server {
+	a.apiServiceCABundlePath = path
+	return a
+}
+
+// installAPIServiceRegistration wires the post-start/pre-shutdown hooks that keep this Server's APIServices
+// in sync with the host cluster, if WithAPIServiceRegistration was called.
+func (a *Server) installAPIServiceRegistration() {
+	if !a.apiServiceRegistrationEnabled {
+		return
+	}
+	a.WithServerFns(func(s *GenericAPIServer) *GenericAPIServer {
+		s.AddPostStartHookOrDie(apiServiceRegistrationHookName,
+			func(hookCtx genericapiserver.PostStartHookContext) error {
+				client, err := aggregatorclient.NewForConfig(hookCtx.LoopbackClientConfig)
+				if err != nil {
+					return err
+				}
+				return a.registerAPIServices(hookCtx.Context, client)
+			})
+		s.AddPreShutdownHookOrDie(apiServiceRegistrationHookName, func() error {
+			client, err := aggregatorclient.NewForConfig(s.LoopbackClientConfig)
+			if err != nil {
+				return err
+			}
+			return a.deregisterAPIServices(context.Background(), client)
+		})
+		return s
+	})
+}
+
+// caBundle returns the CA bundle to embed in registered APIServices, re-reading it from
+// apiServiceCABundlePath on every call when one was configured so rotated certificates take effect on the
+// next registration without requiring a restart.
+func (a *Server) caBundle() ([]byte, error) {
+	if a.apiServiceCABundlePath == "" {
+		return a.apiServiceCABundle, nil
+	}
+	return ioutil.ReadFile(a.apiServiceCABundlePath)
+}
+
+func (a *Server) apiServiceName(gv schema.GroupVersion) string {
+	return fmt.Sprintf("%s.%s", gv.Version, gv.Group)
+}
+
+func (a *Server) registerAPIServices(ctx context.Context, client aggregatorclient.Interface) error {
+	caBundle, err := a.caBundle()
+	if err != nil {
+		return err
+	}
+	for _, gv := range a.orderedGroupVersions {
+		desired := &apiregv1.APIService{
+			ObjectMeta: metav1.ObjectMeta{Name: a.apiServiceName(gv)},
+			Spec: apiregv1.APIServiceSpec{
+				Group:                gv.Group,
+				Version:              gv.Version,
+				GroupPriorityMinimum: a.groupPriorityMinimums[gv.Group],
+				VersionPriority:      a.versionPriorities[gv],
+				Service:              a.apiServiceRef.DeepCopy(),
+				CABundle:             caBundle,
+			},
+		}
+		existing, err := client.ApiregistrationV1().APIServices().Get(ctx, desired.Name, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			if _, err := client.ApiregistrationV1().APIServices().Create(ctx, desired, metav1.CreateOptions{}); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		default:
+			existing.Spec = desired.Spec
+			if _, err := client.ApiregistrationV1().APIServices().Update(ctx, existing, metav1.UpdateOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Server) deregisterAPIServices(ctx context.Context, client aggregatorclient.Interface) error {
+	for _, gv := range a.orderedGroupVersions {
+		name := a.apiServiceName(gv)
+		if err := client.ApiregistrationV1().APIServices().Delete(ctx, name, metav1.DeleteOptions{}); err != nil &&
+			!apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	apiregv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+	aggregatorfake "k8s.io/kube-aggregator/pkg/client/clientset_generated/clientset/fake"
+)
+
+func testAPIServiceServer() (*Server, schema.GroupVersion) {
+	gv := schema.GroupVersion{Group: "tests.example.com", Version: "v1"}
+	a := &Server{
+		orderedGroupVersions:  []schema.GroupVersion{gv},
+		groupPriorityMinimums: map[string]int32{gv.Group: 2000},
+		versionPriorities:     map[schema.GroupVersion]int32{gv: 15},
+	}
+	a.WithAPIServiceRegistration(apiregv1.ServiceReference{Namespace: "default", Name: "my-apiserver"}, []byte("ca-data"))
+	return a, gv
+}
+
+func TestRegisterAPIServicesCreatesWhenMissing(t *testing.T) {
+	a, gv := testAPIServiceServer()
+	client := aggregatorfake.NewSimpleClientset()
+
+	if err := a.registerAPIServices(context.Background(), client); err != nil {
+		t.Fatalf("registerAPIServices: %v", err)
+	}
+
+	got, err := client.ApiregistrationV1().APIServices().Get(context.Background(), a.apiServiceName(gv), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get after registerAPIServices: %v", err)
+	}
+	if got.Spec.GroupPriorityMinimum != 2000 {
+		t.Errorf("GroupPriorityMinimum = %d, want 2000", got.Spec.GroupPriorityMinimum)
+	}
+	if got.Spec.VersionPriority != 15 {
+		t.Errorf("VersionPriority = %d, want 15", got.Spec.VersionPriority)
+	}
+	if string(got.Spec.CABundle) != "ca-data" {
+		t.Errorf("CABundle = %q, want %q", got.Spec.CABundle, "ca-data")
+	}
+}
+
+func TestRegisterAPIServicesUpdatesExisting(t *testing.T) {
+	a, gv := testAPIServiceServer()
+	existing := &apiregv1.APIService{
+		ObjectMeta: metav1.ObjectMeta{Name: a.apiServiceName(gv)},
+		Spec:       apiregv1.APIServiceSpec{Group: gv.Group, Version: gv.Version, GroupPriorityMinimum: 1},
+	}
+	client := aggregatorfake.NewSimpleClientset(existing)
+
+	if err := a.registerAPIServices(context.Background(), client); err != nil {
+		t.Fatalf("registerAPIServices: %v", err)
+	}
+
+	got, err := client.ApiregistrationV1().APIServices().Get(context.Background(), a.apiServiceName(gv), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get after registerAPIServices: %v", err)
+	}
+	if got.Spec.GroupPriorityMinimum != 2000 {
+		t.Errorf("GroupPriorityMinimum = %d, want the new value 2000", got.Spec.GroupPriorityMinimum)
+	}
+}
+
+func TestDeregisterAPIServicesRemovesAndIgnoresMissing(t *testing.T) {
+	a, gv := testAPIServiceServer()
+	existing := &apiregv1.APIService{ObjectMeta: metav1.ObjectMeta{Name: a.apiServiceName(gv)}}
+	client := aggregatorfake.NewSimpleClientset(existing)
+
+	if err := a.deregisterAPIServices(context.Background(), client); err != nil {
+		t.Fatalf("deregisterAPIServices: %v", err)
+	}
+	if _, err := client.ApiregistrationV1().APIServices().Get(
+		context.Background(), a.apiServiceName(gv), metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Get after deregisterAPIServices returned %v, want IsNotFound", err)
+	}
+
+	// Deregistering again (e.g. a retried pre-shutdown hook) must not error just because it's already gone.
+	if err := a.deregisterAPIServices(context.Background(), client); err != nil {
+		t.Errorf("second deregisterAPIServices returned %v, want nil", err)
+	}
+}
+
+func TestCABundleReadsFromPathWhenConfigured(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apiservice-cabundle-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	path := filepath.Join(dir, "ca.crt")
+	if err := ioutil.WriteFile(path, []byte("rotated-ca-data"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	a := &Server{apiServiceCABundle: []byte("stale-ca-data")}
+	a.WithAPIServiceCABundlePath(path)
+
+	bundle, err := a.caBundle()
+	if err != nil {
+		t.Fatalf("caBundle: %v", err)
+	}
+	if string(bundle) != "rotated-ca-data" {
+		t.Errorf("caBundle = %q, want %q (path should take precedence)", bundle, "rotated-ca-data")
+	}
+}
@@ -20,9 +20,11 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -30,6 +32,7 @@ import (
 	"k8s.io/apiserver/pkg/registry/generic"
 	regsitryrest "k8s.io/apiserver/pkg/registry/rest"
 	genericapiserver "k8s.io/apiserver/pkg/server"
+	apiregv1 "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
 	openapicommon "k8s.io/kube-openapi/pkg/common"
 	"sigs.k8s.io/apiserver-runtime/internal/sample-apiserver/pkg/apiserver"
 	"sigs.k8s.io/apiserver-runtime/internal/sample-apiserver/pkg/cmd/server"
@@ -44,6 +47,20 @@ var APIServer = &Server{
 	storage: map[schema.GroupResource]*singletonProvider{},
 }
 
+const (
+	// minAPIPriority is the lowest value accepted for a group or version priority, matching the bound
+	// enforced by the Kubernetes aggregator for APIService.Spec.VersionPriority/GroupPriorityMinimum.
+	minAPIPriority = 1
+	// maxAPIPriority is the highest value accepted for a group or version priority.
+	maxAPIPriority = 20000
+	// defaultVersionPriorityBase is the starting priority handed out to GroupVersions that are registered
+	// without an explicit WithVersionPriority call, preserving first-registered-wins-ties ordering.
+	defaultVersionPriorityBase = 1000
+	// defaultGroupPriorityMinimum is the GroupPriorityMinimum handed to groups that are registered without an
+	// explicit WithGroupPriorityMinimum call, matching the value kube-apiserver's built-in groups use.
+	defaultGroupPriorityMinimum = 1000
+)
+
 // Server builds a new apiserver for a single API group
 type Server struct {
 	errs                 []error
@@ -52,6 +69,80 @@ type Server struct {
 	orderedGroupVersions []schema.GroupVersion
 	schemes              []*runtime.Scheme
 	schemeBuilder        runtime.SchemeBuilder
+
+	// groupPriorityMinimums holds the highest WithGroupPriorityMinimum value registered for each group,
+	// used to rank this group against others in the aggregator.
+	groupPriorityMinimums map[string]int32
+	// versionPriorities holds the WithVersionPriority value registered for each GroupVersion, used to order
+	// versions of the same group relative to one another.
+	versionPriorities map[schema.GroupVersion]int32
+
+	// storageBackend is the default StorageBackend used for resources registered through WithResource that
+	// were not given a specific backend via WithResourceAndBackend. Nil means etcd, via the recommended
+	// options' RESTOptionsGetter, as before this field existed.
+	storageBackend rest.StorageBackend
+
+	// apiServiceRegistrationEnabled is set by WithAPIServiceRegistration to gate the post-start/pre-shutdown
+	// hooks that keep this Server's APIServices in sync with the host cluster.
+	apiServiceRegistrationEnabled bool
+	apiServiceRef                 apiregv1.ServiceReference
+	apiServiceCABundle            []byte
+	apiServiceCABundlePath        string
+
+	// crds and crdPaths hold the CustomResourceDefinitions registered via WithCRDs/WithCRDPath, served by an
+	// embedded apiextensions-apiserver once Build() resolves them.
+	crds     []*apiextv1.CustomResourceDefinition
+	crdPaths []string
+
+	// storageVersions records, for each GroupResource, the GroupVersion whose storage backs every other
+	// registered version of that resource -- the first one forGroupVersionResource saw.
+	storageVersions map[schema.GroupResource]schema.GroupVersion
+	// conversionWebhooks holds the ConversionWebhookConfig registered via WithConversionWebhook, keyed by
+	// GroupResource.
+	conversionWebhooks map[schema.GroupResource]rest.ConversionWebhookConfig
+}
+
+// WithConversionWebhook registers cfg as the conversion webhook for every version of gr other than its
+// storage version: requests against those versions are converted to the storage version by POSTing a
+// ConversionReview (v1, the same wire format CRD conversion webhooks use) to cfg.URL before reaching
+// storage, and responses are converted back the same way. This lets a version of gr's API evolve without
+// every version being linked, as a Go type with scheme conversion funcs, into this binary.
+//
+// WithConversionWebhook must be called before the non-storage-version(s) of gr are registered with
+// WithResource, so forGroupVersionResource can wrap their handler in the conversion delegator.
+func (a *Server) WithConversionWebhook(gr schema.GroupResource, cfg rest.ConversionWebhookConfig) *Server {
+	if a.conversionWebhooks == nil {
+		a.conversionWebhooks = map[schema.GroupResource]rest.ConversionWebhookConfig{}
+	}
+	a.conversionWebhooks[gr] = cfg
+	return a
+}
+
+// WithGroupPriorityMinimum sets the priority the group as a whole is given when the aggregator ranks it
+// against other API groups. Higher numbers are higher priority. This mirrors the "GroupPriorityMinimum"
+// field Kubernetes exposes on APIService: when the same group is registered multiple times (e.g. because it
+// has several versions, or several builders contribute to it), the highest registered value wins for the
+// whole group.
+func (a *Server) WithGroupPriorityMinimum(group string, min int32) *Server {
+	if a.groupPriorityMinimums == nil {
+		a.groupPriorityMinimums = map[string]int32{}
+	}
+	if existing, found := a.groupPriorityMinimums[group]; !found || min > existing {
+		a.groupPriorityMinimums[group] = min
+	}
+	return a
+}
+
+// WithVersionPriority sets the priority used to order this GroupVersion relative to the other versions of
+// the same group. Higher numbers are higher priority. This mirrors the per-APIService "VersionPriority"
+// field Kubernetes uses to rank versions within a group, and feeds both the Scheme's version ordering and
+// the APIService objects registered with the aggregator.
+func (a *Server) WithVersionPriority(gv schema.GroupVersion, priority int32) *Server {
+	if a.versionPriorities == nil {
+		a.versionPriorities = map[schema.GroupVersion]int32{}
+	}
+	a.versionPriorities[gv] = priority
+	return a
 }
 
 // WithOpenAPIDefinitions registers resource OpenAPI definitions generated by openapi-gen.
@@ -134,7 +225,7 @@ func (a *Server) WithResource(obj resource.Object) *Server {
 		return a.forGroupVersionResource(gvr, obj, rest.StaticHandlerProvider{Storage: s.(regsitryrest.Storage)}.Get)
 	}
 
-	_ = a.forGroupVersionResource(gvr, obj, rest.New(obj))
+	_ = a.forGroupVersionResource(gvr, obj, a.defaultHandlerProvider(obj))
 
 	// automatically create status subresource if the object implements the status interface
 	if sgs, ok := obj.(resource.ObjectWithStatusSubResource); ok {
@@ -142,13 +233,30 @@ func (a *Server) WithResource(obj resource.Object) *Server {
 		if s, found := a.storage[st.GroupResource()]; found {
 			_ = a.forGroupVersionResource(st, obj, s.Get)
 		} else {
-			_, _, _, sp := rest.NewStatus(sgs)
-			_ = a.forGroupVersionResource(st, obj, sp)
+			_ = a.forGroupVersionResource(st, obj, a.defaultStatusHandlerProvider(sgs))
 		}
 	}
 	return a
 }
 
+// defaultHandlerProvider returns the ResourceHandlerProvider WithResource falls back to when obj doesn't
+// implement its own storage: backed by a.storageBackend if WithStorageBackend was called, or etcd otherwise.
+func (a *Server) defaultHandlerProvider(obj resource.Object) rest.ResourceHandlerProvider {
+	if a.storageBackend != nil {
+		return rest.NewWithBackend(obj, a.storageBackend)
+	}
+	return rest.New(obj)
+}
+
+// defaultStatusHandlerProvider is defaultHandlerProvider's counterpart for a resource's "status" subresource.
+func (a *Server) defaultStatusHandlerProvider(obj resource.ObjectWithStatusSubResource) rest.ResourceHandlerProvider {
+	if a.storageBackend != nil {
+		return rest.NewStatusWithBackend(obj, a.storageBackend)
+	}
+	_, _, _, sp := rest.NewStatus(obj)
+	return sp
+}
+
 // WithResourceAndStrategy registers the resource with the apiserver creating a new etcd backed storage
 // for the GroupResource using the provided strategy.  In most cases callers should instead use WithResource
 // and implement the interfaces defined in "apiserver-runtime/pkg/builder/rest" to control the Strategy.
@@ -182,6 +290,36 @@ func (a *Server) WithResourceAndHandler(obj resource.Object, sp rest.ResourceHan
 	return a.forGroupVersionResource(gvr, obj, sp)
 }
 
+// WithStorageBackend sets the default StorageBackend used for resources registered through WithResource
+// that do not request a specific backend via WithResourceAndBackend. When backend is anything other than
+// the etcd adapter, Build() skips the etcd RESTOptionsGetter plumbing -- and the RecommendedOptions.Etcd
+// flag group -- in favor of whatever flags backend itself contributes, so an aggregated apiserver can opt
+// out of etcd entirely.
+func (a *Server) WithStorageBackend(backend rest.StorageBackend) *Server {
+	a.storageBackend = backend
+	return a
+}
+
+// WithResourceAndBackend registers the resource with the apiserver, storing it with backend instead of the
+// Server's default backend (etcd, unless WithStorageBackend was also called).  Later versions of the same
+// GroupResource registered with WithResource share this backing store via singletonProvider.
+//
+// Note: WithResourceAndBackend should never be called after the GroupResource has already been registered
+// with another version.
+func (a *Server) WithResourceAndBackend(obj resource.Object, backend rest.StorageBackend) *Server {
+	gvr := obj.GetGroupVersionResource()
+	a.schemeBuilder.Register(resource.AddToScheme(obj))
+
+	_ = a.forGroupVersionResource(gvr, obj, rest.NewWithBackend(obj, backend))
+
+	// automatically create status subresource if the object implements the status interface
+	if sgs, ok := obj.(resource.ObjectWithStatusSubResource); ok {
+		st := gvr.GroupVersion().WithResource(gvr.Resource + "/status")
+		_ = a.forGroupVersionResource(st, obj, rest.NewStatusWithBackend(sgs, backend))
+	}
+	return a
+}
+
 // WithResourceAndStorage registers the resource with the apiserver, applying fn to the storage for the resource
 // before completing it.
 //
@@ -205,6 +343,32 @@ func (a *Server) WithResourceAndStorage(obj resource.Object, fn rest.StoreFn) *S
 	return a
 }
 
+// WithResourceAndIndexers registers the resource with the apiserver creating a new etcd backed storage for
+// the GroupResource, with a GetAttrs function and watch-cache indexers derived from fields installed on it.
+// This turns what would otherwise be a full WithResourceAndStrategy implementation just to support
+// `--field-selector` into a declarative list of fields.
+//
+// Note: WithResourceAndIndexers should never be called after the GroupResource has already been registered
+// with another version.
+func (a *Server) WithResourceAndIndexers(obj resource.Object, fields []rest.FieldIndex) *Server {
+	gvr := obj.GetGroupVersionResource()
+	a.schemeBuilder.Register(resource.AddToScheme(obj))
+
+	indexersFn := rest.WithIndexers(fields)
+	_ = a.forGroupVersionResource(gvr, obj, rest.NewWithFn(obj, indexersFn))
+
+	// automatically create status subresource if the object implements the status interface
+	if _, ok := obj.(resource.ObjectWithStatusSubResource); ok {
+		st := gvr.GroupVersion().WithResource(gvr.Resource + "/status")
+		_ = a.forGroupVersionResource(st, obj, rest.NewStatusWithFn(obj, indexersFn))
+	}
+
+	a.schemeBuilder.Register(func(scheme *runtime.Scheme) error {
+		return rest.RegisterFieldLabelConversions(scheme, obj, fields)
+	})
+	return a
+}
+
 // forGroupVersionResource manually registers storage for a specific resource or subresource version.
 func (a *Server) forGroupVersionResource(
 	gvr schema.GroupVersionResource, obj runtime.Object, sp rest.ResourceHandlerProvider) *Server {
@@ -215,7 +379,17 @@ func (a *Server) forGroupVersionResource(
 	// don't replace the existing instance otherwise it will chain wrapped singletonProviders when
 	// fetching from the map before calling this function
 	if _, found := a.storage[gvr.GroupResource()]; !found {
+		if a.storageVersions == nil {
+			a.storageVersions = map[schema.GroupResource]schema.GroupVersion{}
+		}
+		a.storageVersions[gvr.GroupResource()] = gvr.GroupVersion()
 		a.storage[gvr.GroupResource()] = &singletonProvider{Provider: sp}
+		// serve this first version through the singleton too, so later versions share its storage
+		sp = a.storage[gvr.GroupResource()].Get
+	} else if storageVersion, ok := a.storageVersions[gvr.GroupResource()]; ok && storageVersion != gvr.GroupVersion() {
+		if cfg, ok := a.conversionWebhooks[gvr.GroupResource()]; ok {
+			sp = rest.NewConversionDelegator(sp, gvr.GroupVersion(), storageVersion, gvr.GroupResource(), cfg)
+		}
 	}
 
 	// add the defaulting function for this version to the scheme
@@ -290,6 +464,75 @@ func (a *Server) withGroupVersions(versions ...schema.GroupVersion) *Server {
 	return a
 }
 
+// setDefaultVersionPriorities assigns a version priority to every registered GroupVersion that did not
+// receive one through WithVersionPriority, preserving the existing first-registered-wins ordering.
+func (a *Server) setDefaultVersionPriorities() {
+	if a.versionPriorities == nil {
+		a.versionPriorities = map[schema.GroupVersion]int32{}
+	}
+	for i, gv := range a.orderedGroupVersions {
+		if _, found := a.versionPriorities[gv]; found {
+			continue
+		}
+		priority := int32(defaultVersionPriorityBase - i)
+		if priority < minAPIPriority {
+			priority = minAPIPriority
+		}
+		a.versionPriorities[gv] = priority
+	}
+}
+
+// setDefaultGroupPriorityMinimums assigns defaultGroupPriorityMinimum to every registered group that did not
+// receive one through WithGroupPriorityMinimum, the same way setDefaultVersionPriorities does for version
+// priority.
+func (a *Server) setDefaultGroupPriorityMinimums() {
+	if a.groupPriorityMinimums == nil {
+		a.groupPriorityMinimums = map[string]int32{}
+	}
+	for gv := range a.groupVersions {
+		if _, found := a.groupPriorityMinimums[gv.Group]; found {
+			continue
+		}
+		a.groupPriorityMinimums[gv.Group] = defaultGroupPriorityMinimum
+	}
+}
+
+// validatePriorities checks that every registered GroupVersion has a version priority and every registered
+// group has a group priority minimum, and that every value present falls within the range the aggregator
+// accepts, recording an error for each violation. Call setDefaultVersionPriorities and
+// setDefaultGroupPriorityMinimums first to fill in sensible defaults instead of failing validation here.
+func (a *Server) validatePriorities() {
+	for _, gv := range a.orderedGroupVersions {
+		priority, found := a.versionPriorities[gv]
+		if !found {
+			a.errs = append(a.errs, fmt.Errorf("no version priority registered for %s", gv))
+			continue
+		}
+		if priority < minAPIPriority || priority > maxAPIPriority {
+			a.errs = append(a.errs, fmt.Errorf(
+				"version priority for %s must be between %d and %d, got %d",
+				gv, minAPIPriority, maxAPIPriority, priority))
+		}
+	}
+	seenGroups := map[string]bool{}
+	for gv := range a.groupVersions {
+		if seenGroups[gv.Group] {
+			continue
+		}
+		seenGroups[gv.Group] = true
+		min, found := a.groupPriorityMinimums[gv.Group]
+		if !found {
+			a.errs = append(a.errs, fmt.Errorf("no group priority minimum registered for %s", gv.Group))
+			continue
+		}
+		if min < minAPIPriority || min > maxAPIPriority {
+			a.errs = append(a.errs, fmt.Errorf(
+				"group priority minimum for %s must be between %d and %d, got %d",
+				gv.Group, minAPIPriority, maxAPIPriority, min))
+		}
+	}
+}
+
 // DisableDelegateAuth disables delegated authentication and authorization
 func (a *Server) DisableDelegateAuth() *Server {
 	server.ServerOptionsFns = append(server.ServerOptionsFns, func(o *ServerOptions) *ServerOptions {
@@ -314,6 +557,14 @@ func (a *Server) WithServerFns(fns ...func(server *GenericAPIServer) *GenericAPI
 
 // Build returns a Command used to run the apiserver
 func (a *Server) Build() (*Command, error) {
+	a.setDefaultVersionPriorities()
+	a.setDefaultGroupPriorityMinimums()
+	a.validatePriorities()
+	a.installAPIServiceRegistration()
+	if err := a.installCRDServer(); err != nil {
+		return nil, err
+	}
+
 	a.schemes = append(a.schemes, apiserver.Scheme)
 	a.schemeBuilder.Register(
 		func(scheme *runtime.Scheme) error {
@@ -332,6 +583,11 @@ func (a *Server) Build() (*Command, error) {
 						Version: v,
 					})
 				}
+				// order highest VersionPriority first; SetVersionPriority takes its arguments in
+				// most-preferred-first order.
+				sort.SliceStable(gvs, func(i, j int) bool {
+					return a.versionPriorities[gvs[i]] > a.versionPriorities[gvs[j]]
+				})
 				err := scheme.SetVersionPriority(gvs...)
 				if err != nil {
 					return err
@@ -352,6 +608,11 @@ func (a *Server) Build() (*Command, error) {
 	if len(a.errs) != 0 {
 		return nil, errs{list: a.errs}
 	}
+
+	// A non-nil, non-etcd backend means no resource needs the etcd RESTOptionsGetter; skip wiring its flags
+	// so `--etcd-servers` etc. don't show up (and aren't required) for an apiserver that never talks to etcd.
+	server.SetStorageBackend(a.storageBackend)
+
 	o := server.NewWardleServerOptions(os.Stdout, os.Stderr, a.orderedGroupVersions...)
 	cmd := server.NewCommandStartServer(o, genericapiserver.SetupSignalHandler())
 	cmd.Flags().AddGoFlagSet(flag.CommandLine)
@@ -0,0 +1,153 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/generic"
+	registryrest "k8s.io/apiserver/pkg/registry/rest"
+	"sigs.k8s.io/apiserver-runtime/internal/sample-apiserver/pkg/apiserver"
+	"sigs.k8s.io/apiserver-runtime/pkg/builder/rest"
+)
+
+func writeTestManifest(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatalf("writing test manifest %s: %v", name, err)
+	}
+}
+
+func TestResolveCRDsCombinesDirectAndPathRegistrations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crd-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+
+	writeTestManifest(t, dir, "widgets.yaml", `
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.tests.example.com
+spec:
+  group: tests.example.com
+  names:
+    plural: widgets
+    singular: widget
+    kind: Widget
+  scope: Namespaced
+  versions:
+  - name: v1
+    served: true
+    storage: true
+`)
+	// Non-manifest files alongside the CRDs must be ignored.
+	writeTestManifest(t, dir, "README.md", "not a manifest")
+
+	a := &Server{}
+	a.WithCRDs(&apiextv1.CustomResourceDefinition{ObjectMeta: metav1.ObjectMeta{Name: "gadgets.tests.example.com"}})
+	a.WithCRDPath(dir)
+
+	crds, err := a.resolveCRDs()
+	if err != nil {
+		t.Fatalf("resolveCRDs: %v", err)
+	}
+	if len(crds) != 2 {
+		t.Fatalf("resolveCRDs returned %d CRDs, want 2: %v", len(crds), crds)
+	}
+
+	names := map[string]bool{}
+	for _, crd := range crds {
+		names[crd.Name] = true
+	}
+	if !names["gadgets.tests.example.com"] || !names["widgets.tests.example.com"] {
+		t.Errorf("resolveCRDs returned %v, want both gadgets.tests.example.com and widgets.tests.example.com", names)
+	}
+}
+
+func TestResolveCRDsErrorsOnMissingPath(t *testing.T) {
+	a := &Server{}
+	a.WithCRDPath("/nonexistent/path/for/crd/test")
+
+	if _, err := a.resolveCRDs(); err == nil {
+		t.Error("resolveCRDs on a nonexistent path returned no error")
+	}
+}
+
+// withGoRegisteredGVR registers a stub handler for gvr in apiserver.APIs, the same package-level registry
+// forGroupVersionResource (builder.go) populates for every resource registered via WithResource, and returns
+// a func that restores the prior state so the test doesn't leak it into others.
+func withGoRegisteredGVR(t *testing.T, gvr schema.GroupVersionResource) func() {
+	t.Helper()
+	original, hadOriginal := apiserver.APIs[gvr]
+	apiserver.APIs[gvr] = rest.ResourceHandlerProvider(
+		func(scheme *runtime.Scheme, _ generic.RESTOptionsGetter) (registryrest.Storage, error) {
+			return nil, nil
+		})
+	return func() {
+		if hadOriginal {
+			apiserver.APIs[gvr] = original
+		} else {
+			delete(apiserver.APIs, gvr)
+		}
+	}
+}
+
+func TestCheckCRDConflictsFlagsGoRegisteredGVR(t *testing.T) {
+	gvr := schema.GroupVersionResource{Group: "tests.example.com", Version: "v1", Resource: "widgets"}
+	defer withGoRegisteredGVR(t, gvr)()
+
+	crd := &apiextv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "widgets.tests.example.com"},
+		Spec: apiextv1.CustomResourceDefinitionSpec{
+			Group:    gvr.Group,
+			Names:    apiextv1.CustomResourceDefinitionNames{Plural: gvr.Resource},
+			Versions: []apiextv1.CustomResourceDefinitionVersion{{Name: gvr.Version}},
+		},
+	}
+
+	a := &Server{}
+	a.checkCRDConflicts([]*apiextv1.CustomResourceDefinition{crd})
+
+	if len(a.errs) != 1 {
+		t.Fatalf("checkCRDConflicts recorded %d errors, want 1: %v", len(a.errs), a.errs)
+	}
+}
+
+func TestCheckCRDConflictsIgnoresNonConflictingGVR(t *testing.T) {
+	crd := &apiextv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "gadgets.tests.example.com"},
+		Spec: apiextv1.CustomResourceDefinitionSpec{
+			Group:    "tests.example.com",
+			Names:    apiextv1.CustomResourceDefinitionNames{Plural: "gadgets"},
+			Versions: []apiextv1.CustomResourceDefinitionVersion{{Name: "v1"}},
+		},
+	}
+
+	a := &Server{}
+	a.checkCRDConflicts([]*apiextv1.CustomResourceDefinition{crd})
+
+	if len(a.errs) != 0 {
+		t.Errorf("checkCRDConflicts recorded %v, want no errors for a GVR with no Go-registered conflict", a.errs)
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestSetDefaultVersionPriorities(t *testing.T) {
+	gvA := schema.GroupVersion{Group: "a.example.com", Version: "v1"}
+	gvB := schema.GroupVersion{Group: "a.example.com", Version: "v1beta1"}
+	a := &Server{
+		orderedGroupVersions: []schema.GroupVersion{gvA, gvB},
+		versionPriorities:    map[schema.GroupVersion]int32{gvB: 42},
+	}
+
+	a.setDefaultVersionPriorities()
+
+	if got := a.versionPriorities[gvA]; got != defaultVersionPriorityBase {
+		t.Errorf("versionPriorities[%s] = %d, want %d", gvA, got, defaultVersionPriorityBase)
+	}
+	if got := a.versionPriorities[gvB]; got != 42 {
+		t.Errorf("versionPriorities[%s] = %d, want unchanged 42", gvB, got)
+	}
+}
+
+func TestSetDefaultGroupPriorityMinimums(t *testing.T) {
+	gvA := schema.GroupVersion{Group: "a.example.com", Version: "v1"}
+	gvB := schema.GroupVersion{Group: "b.example.com", Version: "v1"}
+	a := &Server{
+		groupVersions:         map[schema.GroupVersion]bool{gvA: true, gvB: true},
+		groupPriorityMinimums: map[string]int32{"b.example.com": 7000},
+	}
+
+	a.setDefaultGroupPriorityMinimums()
+
+	if got := a.groupPriorityMinimums["a.example.com"]; got != defaultGroupPriorityMinimum {
+		t.Errorf("groupPriorityMinimums[a.example.com] = %d, want %d", got, defaultGroupPriorityMinimum)
+	}
+	if got := a.groupPriorityMinimums["b.example.com"]; got != 7000 {
+		t.Errorf("groupPriorityMinimums[b.example.com] = %d, want unchanged 7000", got)
+	}
+}
+
+func TestValidatePrioritiesCatchesUnsetGroupPriorityMinimum(t *testing.T) {
+	gv := schema.GroupVersion{Group: "a.example.com", Version: "v1"}
+	a := &Server{
+		orderedGroupVersions: []schema.GroupVersion{gv},
+		groupVersions:        map[schema.GroupVersion]bool{gv: true},
+	}
+
+	// Without setDefaultGroupPriorityMinimums, a.groupPriorityMinimums has no entry at all for
+	// "a.example.com", which validatePriorities must flag rather than silently skip.
+	a.setDefaultVersionPriorities()
+	a.validatePriorities()
+
+	if len(a.errs) == 0 {
+		t.Fatal("validatePriorities did not flag an unset (zero-value) group priority minimum")
+	}
+
+	// Once defaulted, the same Server should validate clean.
+	a.errs = nil
+	a.setDefaultGroupPriorityMinimums()
+	a.validatePriorities()
+	if len(a.errs) != 0 {
+		t.Errorf("validatePriorities reported errors after defaulting: %v", a.errs)
+	}
+}
+
+func TestValidatePrioritiesRejectsOutOfRangeValues(t *testing.T) {
+	gv := schema.GroupVersion{Group: "a.example.com", Version: "v1"}
+	a := &Server{
+		orderedGroupVersions:  []schema.GroupVersion{gv},
+		groupVersions:         map[schema.GroupVersion]bool{gv: true},
+		versionPriorities:     map[schema.GroupVersion]int32{gv: maxAPIPriority + 1},
+		groupPriorityMinimums: map[string]int32{"a.example.com": minAPIPriority - 1},
+	}
+
+	a.validatePriorities()
+
+	if len(a.errs) != 2 {
+		t.Fatalf("validatePriorities recorded %d errors, want 2: %v", len(a.errs), a.errs)
+	}
+}
+
+func TestValidatePrioritiesDedupesGroupAcrossVersions(t *testing.T) {
+	gvV1 := schema.GroupVersion{Group: "a.example.com", Version: "v1"}
+	gvV2 := schema.GroupVersion{Group: "a.example.com", Version: "v2"}
+	a := &Server{
+		orderedGroupVersions: []schema.GroupVersion{gvV1, gvV2},
+		groupVersions:        map[schema.GroupVersion]bool{gvV1: true, gvV2: true},
+		versionPriorities:    map[schema.GroupVersion]int32{gvV1: 100, gvV2: 200},
+	}
+
+	a.validatePriorities()
+
+	// Both versions share group "a.example.com", which has no group priority minimum registered; that must
+	// be reported once, not once per version.
+	if len(a.errs) != 1 {
+		t.Fatalf("validatePriorities recorded %d errors, want 1: %v", len(a.errs), a.errs)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apiserver/pkg/registry/generic"
+	genericregistry "k8s.io/apiserver/pkg/registry/generic/registry"
+	registryrest "k8s.io/apiserver/pkg/registry/rest"
+	"sigs.k8s.io/apiserver-runtime/pkg/builder/resource"
+)
+
+// DestroyFunc releases any resources (connections, goroutines, caches) a StorageBackend allocated while
+// building storage for a resource. It is called once, when the apiserver tears that storage down.
+type DestroyFunc func()
+
+// StorageBackend abstracts the persistence layer behind a resource's storage.Interface. WithResource stores
+// resources in etcd by default; implementing this interface lets an aggregated apiserver opt a
+// resource (or all of them, via Server.WithStorageBackend) onto something else entirely -- an in-memory
+// store for tests and demos, or a SQL table.
+type StorageBackend interface {
+	// NewStorage builds the rest.StandardStorage used to serve gvr, plus the DestroyFunc the caller must
+	// invoke once that storage is no longer needed.
+	NewStorage(
+		gvr schema.GroupVersionResource, obj resource.Object, codec runtime.Codec,
+	) (registryrest.StandardStorage, DestroyFunc, error)
+}
+
+// etcdBackend is a StorageBackend adapter for etcd: a genericregistry.Store backed by the etcd3
+// RESTOptionsGetter it was built with. WithResource's default etcd storage is built by New and does not go
+// through this type.
+type etcdBackend struct {
+	optsGetter generic.RESTOptionsGetter
+	strategy   Strategy
+}
+
+// NewETCDBackend returns a StorageBackend that stores objects in etcd through optsGetter using strategy, the
+// same way WithResource's own etcd path does internally. It is exported so that WithResourceAndBackend (or a
+// StorageBackend passed to WithStorageBackend) can compose it for a subset of resources or GroupVersions
+// instead of replacing etcd storage everywhere.
+func NewETCDBackend(optsGetter generic.RESTOptionsGetter, strategy Strategy) StorageBackend {
+	return &etcdBackend{optsGetter: optsGetter, strategy: strategy}
+}
+
+func (e *etcdBackend) NewStorage(
+	gvr schema.GroupVersionResource, obj resource.Object, _ runtime.Codec,
+) (registryrest.StandardStorage, DestroyFunc, error) {
+	store := &genericregistry.Store{
+		NewFunc:                  obj.New,
+		NewListFunc:              obj.NewList,
+		PredicateFunc:            matcher,
+		DefaultQualifiedResource: gvr.GroupResource(),
+		CreateStrategy:           e.strategy,
+		UpdateStrategy:           e.strategy,
+		DeleteStrategy:           e.strategy,
+	}
+	if err := store.CompleteWithOptions(&generic.StoreOptions{RESTOptions: e.optsGetter}); err != nil {
+		return nil, nil, err
+	}
+	return store, store.DestroyFunc, nil
+}
+
+// NewWithBackend returns a ResourceHandlerProvider that stores obj using backend instead of the etcd-backed
+// store NewWithStrategy produces.
+func NewWithBackend(obj resource.Object, backend StorageBackend) ResourceHandlerProvider {
+	return func(scheme *runtime.Scheme, _ generic.RESTOptionsGetter) (registryrest.Storage, error) {
+		gvr := obj.GetGroupVersionResource()
+		codec := serializer.NewCodecFactory(scheme).LegacyCodec(gvr.GroupVersion())
+		storage, destroy, err := backend.NewStorage(gvr, obj, codec)
+		if err != nil {
+			return nil, err
+		}
+		return &destroyingStorage{StandardStorage: storage, destroy: destroy}, nil
+	}
+}
+
+// NewStatusWithBackend returns a ResourceHandlerProvider for obj's "status" subresource, reusing backend so
+// that the main resource and its status share a single backing store.
+func NewStatusWithBackend(obj resource.ObjectWithStatusSubResource, backend StorageBackend) ResourceHandlerProvider {
+	return func(scheme *runtime.Scheme, _ generic.RESTOptionsGetter) (registryrest.Storage, error) {
+		gvr := obj.GetGroupVersionResource()
+		gvr.Resource = gvr.Resource + "/status"
+		codec := serializer.NewCodecFactory(scheme).LegacyCodec(gvr.GroupVersion())
+		storage, destroy, err := backend.NewStorage(gvr, obj, codec)
+		if err != nil {
+			return nil, err
+		}
+		return &statusREST{store: &destroyingStorage{StandardStorage: storage, destroy: destroy}}, nil
+	}
+}
+
+// destroyingStorage wraps the StandardStorage a StorageBackend builds so that the DestroyFunc it returned
+// alongside it runs when the storage is torn down, honoring NewStorage's documented contract that callers
+// invoke that DestroyFunc once the storage is no longer needed -- even for StorageBackend implementations
+// that rely on it for real cleanup (the in-memory and SQL backends in this package return a no-op).
+type destroyingStorage struct {
+	registryrest.StandardStorage
+	destroy DestroyFunc
+}
+
+func (s *destroyingStorage) Destroy() {
+	s.StandardStorage.Destroy()
+	s.destroy()
+}
+
+// statusREST restricts a StandardStorage down to Get/Update, matching the read-and-patch-status-only
+// surface WithResource's etcd-backed status subresource exposes.
+type statusREST struct {
+	store registryrest.StandardStorage
+}
+
+func (r *statusREST) New() runtime.Object { return r.store.New() }
+
+func (r *statusREST) Destroy() { r.store.Destroy() }
+
+func (r *statusREST) Get(
+	ctx context.Context, name string, options *metav1.GetOptions) (runtime.Object, error) {
+	return r.store.Get(ctx, name, options)
+}
+
+func (r *statusREST) Update(
+	ctx context.Context, name string, objInfo registryrest.UpdatedObjectInfo,
+	createValidation registryrest.ValidateObjectFunc, updateValidation registryrest.ValidateObjectUpdateFunc,
+	forceAllowCreate bool, options *metav1.UpdateOptions) (runtime.Object, bool, error) {
+	return r.store.Update(ctx, name, objInfo, createValidation, updateValidation, forceAllowCreate, options)
+}
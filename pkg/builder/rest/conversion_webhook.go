@@ -0,0 +1,449 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/registry/generic"
+	registryrest "k8s.io/apiserver/pkg/registry/rest"
+)
+
+// FailurePolicyType mirrors the FailurePolicyType Kubernetes admission webhooks use: it controls what
+// happens when the conversion webhook itself cannot be reached or errors out.
+type FailurePolicyType string
+
+const (
+	// Fail means a conversion webhook failure fails the request. This is the default, matching CRD
+	// conversion webhooks.
+	Fail FailurePolicyType = "Fail"
+	// Ignore means a conversion webhook failure is ignored and the object is passed through unconverted.
+	Ignore FailurePolicyType = "Ignore"
+)
+
+// ConversionWebhookConfig configures the external endpoint WithConversionWebhook calls to convert objects
+// between a resource's storage version and any other version registered for the same GroupResource.
+type ConversionWebhookConfig struct {
+	// URL is the HTTPS endpoint the ConversionReview request is POSTed to.
+	URL string
+	// CABundle is the PEM-encoded CA bundle used to validate the webhook server's certificate. If empty, the
+	// host's system roots are used.
+	CABundle []byte
+	// Timeout bounds each call to the webhook. Defaults to 10 seconds.
+	Timeout time.Duration
+	// RetryBackoff controls retries of failed calls before FailurePolicy is applied. The zero value disables
+	// retries (a single attempt).
+	RetryBackoff wait.Backoff
+	// FailurePolicy controls what happens if the webhook cannot be reached, times out, or returns a non-2xx
+	// or non-Success response after retries are exhausted. Defaults to Fail.
+	FailurePolicy FailurePolicyType
+}
+
+func (cfg ConversionWebhookConfig) timeout() time.Duration {
+	if cfg.Timeout > 0 {
+		return cfg.Timeout
+	}
+	return 10 * time.Second
+}
+
+func (cfg ConversionWebhookConfig) failurePolicy() FailurePolicyType {
+	if cfg.FailurePolicy == "" {
+		return Fail
+	}
+	return cfg.FailurePolicy
+}
+
+// webhookConverter POSTs ConversionReview v1 requests (the same wire format CRD conversion webhooks use) to
+// convert objects between API versions of a single GroupResource.
+type webhookConverter struct {
+	cfg    ConversionWebhookConfig
+	client *http.Client
+}
+
+var (
+	convertersMu sync.Mutex
+	converters   = map[schema.GroupResource]*webhookConverter{}
+)
+
+// converterFor returns the cached webhookConverter for gr, creating one from cfg the first time it is
+// requested.
+func converterFor(gr schema.GroupResource, cfg ConversionWebhookConfig) (*webhookConverter, error) {
+	convertersMu.Lock()
+	defer convertersMu.Unlock()
+	if c, ok := converters[gr]; ok {
+		return c, nil
+	}
+	client, err := newWebhookClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("building conversion webhook client for %s: %w", gr, err)
+	}
+	c := &webhookConverter{cfg: cfg, client: client}
+	converters[gr] = c
+	return c, nil
+}
+
+func newWebhookClient(cfg ConversionWebhookConfig) (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+	if len(cfg.CABundle) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(cfg.CABundle) {
+			return nil, fmt.Errorf("no certificates found in CABundle")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &http.Client{
+		Timeout:   cfg.timeout(),
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}, nil
+}
+
+// convert sends obj to the webhook and returns its representation in toVersion. On failure it either
+// returns an error (FailurePolicy Fail) or obj unchanged (FailurePolicy Ignore).
+//
+// scheme is used two ways: to stamp obj's GroupVersionKind onto the outgoing request when obj's own TypeMeta
+// is empty (as is normal for objects decoded by the apiserver), so the webhook server can tell what version
+// it is converting from; and to decode the webhook's response, which -- like a CRD conversion webhook's --
+// only populates RawExtension.Raw, not RawExtension.Object.
+func (c *webhookConverter) convert(scheme *runtime.Scheme, obj runtime.Object, toVersion string) (runtime.Object, error) {
+	stamped := obj.DeepCopyObject()
+	if stamped.GetObjectKind().GroupVersionKind().Empty() {
+		if gvks, _, err := scheme.ObjectKinds(obj); err == nil && len(gvks) > 0 {
+			stamped.GetObjectKind().SetGroupVersionKind(gvks[0])
+		}
+	}
+
+	review := &apiextv1.ConversionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apiextensions.k8s.io/v1", Kind: "ConversionReview"},
+		Request: &apiextv1.ConversionRequest{
+			UID:               uuid.NewUUID(),
+			DesiredAPIVersion: toVersion,
+			Objects:           []runtime.RawExtension{{Object: stamped}},
+		},
+	}
+
+	result, err := c.call(review)
+	if err != nil {
+		if c.cfg.failurePolicy() == Ignore {
+			return obj, nil
+		}
+		return nil, err
+	}
+	if result.Response == nil || result.Response.Result.Status != metav1.StatusSuccess {
+		err := fmt.Errorf("conversion webhook %s rejected the request: %+v", c.cfg.URL, result.Response)
+		if c.cfg.failurePolicy() == Ignore {
+			return obj, nil
+		}
+		return nil, err
+	}
+	if len(result.Response.ConvertedObjects) != 1 {
+		err := fmt.Errorf(
+			"conversion webhook %s returned %d objects, expected 1", c.cfg.URL, len(result.Response.ConvertedObjects))
+		if c.cfg.failurePolicy() == Ignore {
+			return obj, nil
+		}
+		return nil, err
+	}
+
+	converted, _, err := serializer.NewCodecFactory(scheme).UniversalDeserializer().Decode(
+		result.Response.ConvertedObjects[0].Raw, nil, nil)
+	if err != nil {
+		err = fmt.Errorf("decoding object returned by conversion webhook %s: %w", c.cfg.URL, err)
+		if c.cfg.failurePolicy() == Ignore {
+			return obj, nil
+		}
+		return nil, err
+	}
+	return converted, nil
+}
+
+func (c *webhookConverter) call(review *apiextv1.ConversionReview) (*apiextv1.ConversionReview, error) {
+	body, err := json.Marshal(review)
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := c.cfg.RetryBackoff
+	if backoff.Steps == 0 {
+		backoff.Steps = 1
+	}
+
+	var lastErr error
+	for i := 0; i < backoff.Steps; i++ {
+		if i > 0 {
+			time.Sleep(backoff.Step())
+		}
+		result, err := c.doRequest(body)
+		if err == nil {
+			return result, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (c *webhookConverter) doRequest(body []byte) (*apiextv1.ConversionReview, error) {
+	req, err := http.NewRequest(http.MethodPost, c.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("conversion webhook %s returned status %d", c.cfg.URL, resp.StatusCode)
+	}
+	decoded := &apiextv1.ConversionReview{}
+	if err := json.NewDecoder(resp.Body).Decode(decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// NewConversionDelegator wraps inner so that, once it builds the shared storage for a GroupResource,
+// requests made against requestVersion are converted to storageVersion before reaching it, and its
+// responses are converted back to requestVersion, via the webhook cfg describes.
+func NewConversionDelegator(
+	inner ResourceHandlerProvider, requestVersion, storageVersion schema.GroupVersion,
+	gr schema.GroupResource, cfg ConversionWebhookConfig) ResourceHandlerProvider {
+	return func(scheme *runtime.Scheme, optsGetter generic.RESTOptionsGetter) (registryrest.Storage, error) {
+		storage, err := inner(scheme, optsGetter)
+		if err != nil {
+			return nil, err
+		}
+		standard, ok := storage.(registryrest.StandardStorage)
+		if !ok {
+			// Nothing to convert through for handlers that don't expose the full CRUD surface (e.g. a
+			// custom rest.Connecter); serve them unconverted.
+			return storage, nil
+		}
+		converter, err := converterFor(gr, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &conversionDelegator{
+			StandardStorage: standard,
+			scheme:          scheme,
+			converter:       converter,
+			requestVersion:  requestVersion.String(),
+			storageVersion:  storageVersion.String(),
+		}, nil
+	}
+}
+
+// conversionDelegator serves a GroupVersion other than a resource's storage version by converting objects
+// to and from the storage version through a webhookConverter around Get, List, Create, Update, Delete and
+// Watch calls to the shared storage. Other calls, such as DeleteCollection, go straight to the shared storage
+// and return objects in the storage version.
+type conversionDelegator struct {
+	registryrest.StandardStorage
+	scheme         *runtime.Scheme
+	converter      *webhookConverter
+	requestVersion string
+	storageVersion string
+}
+
+func (d *conversionDelegator) Get(
+	ctx context.Context, name string, options *metav1.GetOptions) (runtime.Object, error) {
+	obj, err := d.StandardStorage.Get(ctx, name, options)
+	if err != nil {
+		return nil, err
+	}
+	return d.converter.convert(d.scheme, obj, d.requestVersion)
+}
+
+func (d *conversionDelegator) List(
+	ctx context.Context, options *registryrest.ListOptions) (runtime.Object, error) {
+	list, err := d.StandardStorage.List(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return nil, err
+	}
+	converted := make([]runtime.Object, 0, len(items))
+	for _, item := range items {
+		out, err := d.converter.convert(d.scheme, item, d.requestVersion)
+		if err != nil {
+			return nil, err
+		}
+		converted = append(converted, out)
+	}
+	out := list.DeepCopyObject()
+	if err := meta.SetList(out, converted); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (d *conversionDelegator) Create(
+	ctx context.Context, obj runtime.Object, createValidation registryrest.ValidateObjectFunc,
+	options *metav1.CreateOptions) (runtime.Object, error) {
+	storageObj, err := d.converter.convert(d.scheme, obj, d.storageVersion)
+	if err != nil {
+		return nil, err
+	}
+	created, err := d.StandardStorage.Create(ctx, storageObj, createValidation, options)
+	if err != nil {
+		return nil, err
+	}
+	return d.converter.convert(d.scheme, created, d.requestVersion)
+}
+
+func (d *conversionDelegator) Update(
+	ctx context.Context, name string, objInfo registryrest.UpdatedObjectInfo,
+	createValidation registryrest.ValidateObjectFunc, updateValidation registryrest.ValidateObjectUpdateFunc,
+	forceAllowCreate bool, options *metav1.UpdateOptions) (runtime.Object, bool, error) {
+	wrapped := &convertingUpdatedObjectInfo{
+		inner:          objInfo,
+		scheme:         d.scheme,
+		converter:      d.converter,
+		requestVersion: d.requestVersion,
+		storageVersion: d.storageVersion,
+	}
+	updated, created, err := d.StandardStorage.Update(
+		ctx, name, wrapped, createValidation, updateValidation, forceAllowCreate, options)
+	if err != nil {
+		return nil, created, err
+	}
+	out, err := d.converter.convert(d.scheme, updated, d.requestVersion)
+	return out, created, err
+}
+
+func (d *conversionDelegator) Delete(
+	ctx context.Context, name string, deleteValidation registryrest.ValidateObjectFunc,
+	options *metav1.DeleteOptions) (runtime.Object, bool, error) {
+	obj, immediate, err := d.StandardStorage.Delete(ctx, name, deleteValidation, options)
+	if err != nil {
+		return nil, immediate, err
+	}
+	out, err := d.converter.convert(d.scheme, obj, d.requestVersion)
+	return out, immediate, err
+}
+
+func (d *conversionDelegator) Watch(
+	ctx context.Context, options *registryrest.ListOptions) (watch.Interface, error) {
+	inner, err := d.StandardStorage.Watch(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	return newConvertingWatch(inner, d.scheme, d.converter, d.requestVersion), nil
+}
+
+// convertingUpdatedObjectInfo presents an UpdatedObjectInfo built against an object in requestVersion over
+// one that operates in storageVersion, converting the "old" object down before delegating and the "new"
+// object back up afterward.
+type convertingUpdatedObjectInfo struct {
+	inner          registryrest.UpdatedObjectInfo
+	scheme         *runtime.Scheme
+	converter      *webhookConverter
+	requestVersion string
+	storageVersion string
+}
+
+func (c *convertingUpdatedObjectInfo) Preconditions() *metav1.Preconditions {
+	return c.inner.Preconditions()
+}
+
+func (c *convertingUpdatedObjectInfo) UpdatedObject(
+	ctx context.Context, oldObj runtime.Object) (runtime.Object, error) {
+	oldInRequestVersion, err := c.converter.convert(c.scheme, oldObj, c.requestVersion)
+	if err != nil {
+		return nil, err
+	}
+	newObj, err := c.inner.UpdatedObject(ctx, oldInRequestVersion)
+	if err != nil {
+		return nil, err
+	}
+	return c.converter.convert(c.scheme, newObj, c.storageVersion)
+}
+
+// convertingWatch wraps a watch.Interface, converting each event's Object to targetVersion as it is
+// delivered.
+type convertingWatch struct {
+	inner     watch.Interface
+	scheme    *runtime.Scheme
+	converter *webhookConverter
+	target    string
+	out       chan watch.Event
+	stop      chan struct{}
+}
+
+func newConvertingWatch(
+	inner watch.Interface, scheme *runtime.Scheme, converter *webhookConverter, target string) watch.Interface {
+	w := &convertingWatch{
+		inner:     inner,
+		scheme:    scheme,
+		converter: converter,
+		target:    target,
+		out:       make(chan watch.Event),
+		stop:      make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *convertingWatch) run() {
+	defer close(w.out)
+	for {
+		select {
+		case event, ok := <-w.inner.ResultChan():
+			if !ok {
+				return
+			}
+			if event.Type != watch.Error {
+				if converted, err := w.converter.convert(w.scheme, event.Object, w.target); err == nil {
+					event.Object = converted
+				}
+			}
+			select {
+			case w.out <- event:
+			case <-w.stop:
+				return
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *convertingWatch) Stop() {
+	close(w.stop)
+	w.inner.Stop()
+}
+
+func (w *convertingWatch) ResultChan() <-chan watch.Event { return w.out }
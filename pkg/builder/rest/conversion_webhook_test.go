@@ -0,0 +1,154 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// newConversionTestScheme registers fixture under a single GVK, enough for the webhook response's embedded
+// TypeMeta to be resolved by the UniversalDeserializer.
+func newConversionTestScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(
+		schema.GroupVersionKind{Group: "tests.example.com", Version: "v1", Kind: "Fixture"}, &fixture{})
+	return scheme
+}
+
+// newFakeConversionWebhook starts a TLS test server that decodes the incoming ConversionReview's Raw object,
+// uppercases its Value, and returns it as the lone converted object, recording the request's stamped
+// APIVersion/Kind for the caller to assert on.
+func newFakeConversionWebhook(t *testing.T, receivedAPIVersion, receivedKind *string) *httptest.Server {
+	t.Helper()
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var review apiextv1.ConversionReview
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			t.Errorf("server: decoding request: %v", err)
+			return
+		}
+		if len(review.Request.Objects) != 1 {
+			t.Errorf("server: got %d objects, want 1", len(review.Request.Objects))
+			return
+		}
+
+		var in fixture
+		if err := json.Unmarshal(review.Request.Objects[0].Raw, &in); err != nil {
+			t.Errorf("server: unmarshaling object: %v", err)
+			return
+		}
+		*receivedAPIVersion = in.TypeMeta.APIVersion
+		*receivedKind = in.TypeMeta.Kind
+
+		out := fixture{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "tests.example.com/v1", Kind: "Fixture"},
+			ObjectMeta: in.ObjectMeta,
+			Value:      strings.ToUpper(in.Value),
+		}
+		outRaw, err := json.Marshal(out)
+		if err != nil {
+			t.Errorf("server: marshaling response: %v", err)
+			return
+		}
+
+		resp := apiextv1.ConversionReview{
+			Response: &apiextv1.ConversionResponse{
+				UID:              review.Request.UID,
+				Result:           metav1.Status{Status: metav1.StatusSuccess},
+				ConvertedObjects: []runtime.RawExtension{{Raw: outRaw}},
+			},
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			t.Errorf("server: encoding response: %v", err)
+		}
+	}))
+}
+
+func TestWebhookConverterConvertRoundTrip(t *testing.T) {
+	var receivedAPIVersion, receivedKind string
+	server := newFakeConversionWebhook(t, &receivedAPIVersion, &receivedKind)
+	defer server.Close()
+
+	scheme := newConversionTestScheme()
+	conv := &webhookConverter{cfg: ConversionWebhookConfig{URL: server.URL}, client: server.Client()}
+
+	in := &fixture{ObjectMeta: metav1.ObjectMeta{Name: "a"}, Value: "v1"}
+	out, err := conv.convert(scheme, in, "tests.example.com/v1")
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+
+	f, ok := out.(*fixture)
+	if !ok {
+		t.Fatalf("convert returned %T, want *fixture", out)
+	}
+	if f.Value != "V1" {
+		t.Errorf("convert returned Value %q, want %q (server should have uppercased it)", f.Value, "V1")
+	}
+
+	// obj's TypeMeta was empty, as it would be coming out of apiserver storage; convert must have stamped it
+	// before sending so the webhook server can tell what version it's converting from.
+	if receivedAPIVersion != "tests.example.com/v1" || receivedKind != "Fixture" {
+		t.Errorf("webhook received APIVersion=%q Kind=%q, want tests.example.com/v1 Fixture",
+			receivedAPIVersion, receivedKind)
+	}
+}
+
+func TestWebhookConverterConvertFailurePolicyIgnore(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	scheme := newConversionTestScheme()
+	conv := &webhookConverter{
+		cfg:    ConversionWebhookConfig{URL: server.URL, FailurePolicy: Ignore},
+		client: server.Client(),
+	}
+
+	in := &fixture{ObjectMeta: metav1.ObjectMeta{Name: "a"}, Value: "v1"}
+	out, err := conv.convert(scheme, in, "tests.example.com/v1")
+	if err != nil {
+		t.Fatalf("convert with FailurePolicy Ignore returned error: %v", err)
+	}
+	if out != runtime.Object(in) {
+		t.Errorf("convert with FailurePolicy Ignore did not pass obj through unchanged")
+	}
+}
+
+func TestWebhookConverterConvertFailurePolicyFail(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	scheme := newConversionTestScheme()
+	conv := &webhookConverter{cfg: ConversionWebhookConfig{URL: server.URL}, client: server.Client()}
+
+	in := &fixture{ObjectMeta: metav1.ObjectMeta{Name: "a"}, Value: "v1"}
+	if _, err := conv.convert(scheme, in, "tests.example.com/v1"); err == nil {
+		t.Error("convert with default FailurePolicy (Fail) returned no error for a failing webhook")
+	}
+}
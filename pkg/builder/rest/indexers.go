@@ -0,0 +1,183 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
+	genericregistry "k8s.io/apiserver/pkg/registry/generic/registry"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/apiserver-runtime/pkg/builder/resource"
+)
+
+// FieldIndex declares a single field made selectable in list/watch requests for a resource -- e.g.
+// `--field-selector spec.nodeName=foo` -- and indexed by the watch cache so such requests don't fall back to
+// a full scan.
+type FieldIndex struct {
+	// Name is the field path selectors are matched against, e.g. "spec.nodeName".
+	Name string
+	// Extract returns the field's value for obj. It must be side-effect free and fast: it runs for every
+	// object considered by a list/watch using this field, and for every watch event.
+	Extract func(runtime.Object) string
+	// AllowedOperators restricts which selection.Operators (Equals, NotEquals, ...) this field accepts. A
+	// list/watch request using a field selector with a different operator against this field fails with an
+	// error instead of being silently evaluated. A nil slice allows every operator.
+	AllowedOperators []selection.Operator
+}
+
+// WithIndexers returns a StoreFn that installs a GetAttrs function and cache.Indexers derived from indexes,
+// so that list/watch requests filtering on one of the declared fields are served from the indexer instead of
+// evaluating the selector against every object.
+func WithIndexers(indexes []FieldIndex) StoreFn {
+	getAttrs := attrsFuncFor(indexes)
+	allowed := allowedOperatorsFor(indexes)
+	indexFields := make([]string, 0, len(indexes))
+	for _, idx := range indexes {
+		indexFields = append(indexFields, idx.Name)
+	}
+	cacheIndexers := cacheIndexersFor(indexes)
+	return func(store *genericregistry.Store) {
+		store.PredicateFunc = func(label labels.Selector, field fields.Selector) storage.SelectionPredicate {
+			return storage.SelectionPredicate{
+				Label:       label,
+				Field:       field,
+				GetAttrs:    rejectDisallowedOperators(getAttrs, field, allowed),
+				IndexFields: indexFields,
+			}
+		}
+		store.Indexers = cacheIndexers
+	}
+}
+
+// allowedOperatorsFor collects the AllowedOperators declared for each named field, omitting fields that
+// didn't restrict their operators at all.
+func allowedOperatorsFor(indexes []FieldIndex) map[string][]selection.Operator {
+	allowed := make(map[string][]selection.Operator, len(indexes))
+	for _, idx := range indexes {
+		if idx.AllowedOperators != nil {
+			allowed[idx.Name] = idx.AllowedOperators
+		}
+	}
+	return allowed
+}
+
+// rejectDisallowedOperators wraps attrs so that, if field's Requirements use an operator one of the declared
+// FieldIndexes didn't list in AllowedOperators, every call returns an error instead of silently matching as
+// if the restriction didn't exist. The check runs once and its result is reused for every object, since
+// field -- and therefore its Requirements -- doesn't change between calls for a single list/watch request.
+func rejectDisallowedOperators(
+	attrs storage.AttrFunc, field fields.Selector, allowed map[string][]selection.Operator) storage.AttrFunc {
+	var rejectErr error
+	checked := false
+	return func(obj runtime.Object) (labels.Set, fields.Set, error) {
+		if !checked {
+			checked = true
+			if field != nil {
+				for _, req := range field.Requirements() {
+					ops, restricted := allowed[req.Field]
+					if !restricted {
+						continue
+					}
+					if !operatorAllowed(req.Operator, ops) {
+						rejectErr = fmt.Errorf(
+							"field selector operator %q is not allowed for field %q", req.Operator, req.Field)
+						break
+					}
+				}
+			}
+		}
+		if rejectErr != nil {
+			return nil, nil, rejectErr
+		}
+		return attrs(obj)
+	}
+}
+
+func operatorAllowed(op selection.Operator, allowed []selection.Operator) bool {
+	for _, a := range allowed {
+		if a == op {
+			return true
+		}
+	}
+	return false
+}
+
+// attrsFuncFor builds the storage.AttrFunc GetAttrs uses to compute the fields.Set of a stored object from
+// indexes, alongside its labels.Set.
+func attrsFuncFor(indexes []FieldIndex) storage.AttrFunc {
+	return func(obj runtime.Object) (labels.Set, fields.Set, error) {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return nil, nil, err
+		}
+		fieldSet := make(fields.Set, len(indexes))
+		for _, idx := range indexes {
+			fieldSet[idx.Name] = idx.Extract(obj)
+		}
+		return labels.Set(accessor.GetLabels()), fieldSet, nil
+	}
+}
+
+// cacheIndexersFor builds the cache.Indexers the watch cache uses to evaluate field selectors over indexes
+// without scanning every object.
+func cacheIndexersFor(indexes []FieldIndex) *cache.Indexers {
+	indexers := cache.Indexers{}
+	for _, idx := range indexes {
+		idx := idx
+		indexers[storage.FieldIndex(idx.Name)] = func(obj interface{}) ([]string, error) {
+			runtimeObj, ok := obj.(runtime.Object)
+			if !ok {
+				return nil, fmt.Errorf("object of type %T is not a runtime.Object", obj)
+			}
+			return []string{idx.Extract(runtimeObj)}, nil
+		}
+	}
+	return &indexers
+}
+
+// RegisterFieldLabelConversions advertises indexes as the field selectors obj's registered
+// GroupVersionKinds accept (in addition to the universal "metadata.name"/"metadata.namespace"), so clients
+// get an explicit "field label not supported" error for anything else instead of a selector that silently
+// matches nothing.
+func RegisterFieldLabelConversions(scheme *runtime.Scheme, obj resource.Object, indexes []FieldIndex) error {
+	gvks, _, err := scheme.ObjectKinds(obj)
+	if err != nil {
+		return err
+	}
+	allowed := make(map[string]bool, len(indexes))
+	for _, idx := range indexes {
+		allowed[idx.Name] = true
+	}
+	convert := func(label, value string) (string, string, error) {
+		if allowed[label] || label == "metadata.name" || label == "metadata.namespace" {
+			return label, value, nil
+		}
+		return "", "", fmt.Errorf("field label not supported: %s", label)
+	}
+	for _, gvk := range gvks {
+		if err := scheme.AddFieldLabelConversionFunc(gvk, convert); err != nil {
+			return err
+		}
+	}
+	return nil
+}
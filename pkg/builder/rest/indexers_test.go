@@ -0,0 +1,97 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+func TestAttrsFuncForExtractsFields(t *testing.T) {
+	indexes := []FieldIndex{
+		{Name: "spec.value", Extract: func(obj runtime.Object) string { return obj.(*fixture).Value }},
+	}
+	obj := &fixture{ObjectMeta: metav1.ObjectMeta{Name: "a", Labels: map[string]string{"team": "x"}}, Value: "v1"}
+
+	labelSet, fieldSet, err := attrsFuncFor(indexes)(obj)
+	if err != nil {
+		t.Fatalf("attrsFuncFor: %v", err)
+	}
+	if got := fieldSet["spec.value"]; got != "v1" {
+		t.Errorf("fieldSet[spec.value] = %q, want %q", got, "v1")
+	}
+	if got := labelSet["team"]; got != "x" {
+		t.Errorf("labelSet[team] = %q, want %q", got, "x")
+	}
+}
+
+func TestRejectDisallowedOperatorsAllowsConfiguredOperator(t *testing.T) {
+	indexes := []FieldIndex{
+		{
+			Name:             "spec.value",
+			Extract:          func(obj runtime.Object) string { return obj.(*fixture).Value },
+			AllowedOperators: []selection.Operator{selection.Equals},
+		},
+	}
+	selector := fields.OneTermEqualSelector("spec.value", "v1")
+	attrs := rejectDisallowedOperators(attrsFuncFor(indexes), selector, allowedOperatorsFor(indexes))
+
+	obj := &fixture{ObjectMeta: metav1.ObjectMeta{Name: "a"}, Value: "v1"}
+	if _, _, err := attrs(obj); err != nil {
+		t.Errorf("attrs with an allowed operator returned error: %v", err)
+	}
+}
+
+func TestRejectDisallowedOperatorsRejectsDisallowedOperator(t *testing.T) {
+	indexes := []FieldIndex{
+		{
+			Name:             "spec.value",
+			Extract:          func(obj runtime.Object) string { return obj.(*fixture).Value },
+			AllowedOperators: []selection.Operator{selection.Equals},
+		},
+	}
+	selector := fields.OneTermNotEqualSelector("spec.value", "v1")
+	attrs := rejectDisallowedOperators(attrsFuncFor(indexes), selector, allowedOperatorsFor(indexes))
+
+	obj := &fixture{ObjectMeta: metav1.ObjectMeta{Name: "a"}, Value: "v2"}
+	if _, _, err := attrs(obj); err == nil {
+		t.Error("attrs with a disallowed operator returned no error")
+	}
+
+	// A second call must still reject without re-deriving the error from the object, so it is consistent for
+	// every item in a list.
+	if _, _, err := attrs(obj); err == nil {
+		t.Error("second attrs call with a disallowed operator returned no error")
+	}
+}
+
+func TestRejectDisallowedOperatorsIgnoresUnrestrictedFields(t *testing.T) {
+	indexes := []FieldIndex{
+		{Name: "spec.value", Extract: func(obj runtime.Object) string { return obj.(*fixture).Value }},
+	}
+	selector := fields.OneTermNotEqualSelector("spec.value", "v1")
+	attrs := rejectDisallowedOperators(attrsFuncFor(indexes), selector, allowedOperatorsFor(indexes))
+
+	obj := &fixture{ObjectMeta: metav1.ObjectMeta{Name: "a"}, Value: "v2"}
+	if _, _, err := attrs(obj); err != nil {
+		t.Errorf("attrs on a field with no AllowedOperators returned error: %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	registryrest "k8s.io/apiserver/pkg/registry/rest"
+	"sigs.k8s.io/apiserver-runtime/pkg/builder/resource"
+)
+
+// memoryBackend is a StorageBackend that keeps objects in an in-process map rather than etcd. It is meant
+// for unit tests and local demos of an aggregated apiserver: restarting the process loses all data, and
+// there is no support for multiple apiserver replicas sharing state.
+type memoryBackend struct{}
+
+// NewInMemoryBackend returns a StorageBackend that stores objects in memory instead of etcd. It is not
+// suitable for production use -- state is neither persisted nor shared across replicas -- but is convenient
+// for tests and for demoing an aggregated apiserver without standing up etcd.
+func NewInMemoryBackend() StorageBackend {
+	return memoryBackend{}
+}
+
+func (memoryBackend) NewStorage(
+	gvr schema.GroupVersionResource, obj resource.Object, _ runtime.Codec,
+) (registryrest.StandardStorage, DestroyFunc, error) {
+	s := &memoryStorage{
+		gr:      gvr.GroupResource(),
+		newFunc: obj.New,
+		newListFunc: obj.NewList,
+		objects: map[string]runtime.Object{},
+	}
+	return s, func() {}, nil
+}
+
+// memoryStorage implements registryrest.StandardStorage over a plain map guarded by a mutex. Keys are
+// "namespace/name" (or just "name" for cluster-scoped resources).
+type memoryStorage struct {
+	mu          sync.RWMutex
+	gr          schema.GroupResource
+	newFunc     func() runtime.Object
+	newListFunc func() runtime.Object
+	objects     map[string]runtime.Object
+}
+
+func (s *memoryStorage) New() runtime.Object { return s.newFunc() }
+
+func (s *memoryStorage) NewList() runtime.Object { return s.newListFunc() }
+
+func (s *memoryStorage) Destroy() {}
+
+func (s *memoryStorage) NamespaceScoped() bool {
+	return s.newFunc().(resource.Object).NamespaceScoped()
+}
+
+func key(ctx context.Context, name string) string {
+	if ns, ok := genericapirequest.NamespaceFrom(ctx); ok && ns != "" {
+		return ns + "/" + name
+	}
+	return name
+}
+
+func (s *memoryStorage) Get(
+	ctx context.Context, name string, _ *metav1.GetOptions) (runtime.Object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	obj, found := s.objects[key(ctx, name)]
+	if !found {
+		return nil, apierrors.NewNotFound(s.gr, name)
+	}
+	return obj.DeepCopyObject(), nil
+}
+
+func (s *memoryStorage) List(ctx context.Context, _ *registryrest.ListOptions) (runtime.Object, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := s.newListFunc()
+	items := make([]runtime.Object, 0, len(s.objects))
+	for _, obj := range s.objects {
+		items = append(items, obj.DeepCopyObject())
+	}
+	if err := meta.SetList(list, items); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *memoryStorage) Create(
+	ctx context.Context, obj runtime.Object, createValidation registryrest.ValidateObjectFunc,
+	_ *metav1.CreateOptions) (runtime.Object, error) {
+	if createValidation != nil {
+		if err := createValidation(ctx, obj); err != nil {
+			return nil, err
+		}
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := key(ctx, accessor.GetName())
+	if _, found := s.objects[k]; found {
+		return nil, apierrors.NewAlreadyExists(s.gr, accessor.GetName())
+	}
+	s.objects[k] = obj.DeepCopyObject()
+	return obj, nil
+}
+
+func (s *memoryStorage) Update(
+	ctx context.Context, name string, objInfo registryrest.UpdatedObjectInfo,
+	createValidation registryrest.ValidateObjectFunc, updateValidation registryrest.ValidateObjectUpdateFunc,
+	forceAllowCreate bool, _ *metav1.UpdateOptions) (runtime.Object, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := key(ctx, name)
+	old, found := s.objects[k]
+	if !found && !forceAllowCreate {
+		return nil, false, apierrors.NewNotFound(s.gr, name)
+	}
+	updated, err := objInfo.UpdatedObject(ctx, old)
+	if err != nil {
+		return nil, false, err
+	}
+	if found {
+		if updateValidation != nil {
+			if err := updateValidation(ctx, updated, old); err != nil {
+				return nil, false, err
+			}
+		}
+	} else if createValidation != nil {
+		if err := createValidation(ctx, updated); err != nil {
+			return nil, false, err
+		}
+	}
+	s.objects[k] = updated.DeepCopyObject()
+	return updated, !found, nil
+}
+
+func (s *memoryStorage) Delete(
+	ctx context.Context, name string, deleteValidation registryrest.ValidateObjectFunc,
+	_ *metav1.DeleteOptions) (runtime.Object, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := key(ctx, name)
+	obj, found := s.objects[k]
+	if !found {
+		return nil, false, apierrors.NewNotFound(s.gr, name)
+	}
+	if deleteValidation != nil {
+		if err := deleteValidation(ctx, obj); err != nil {
+			return nil, false, err
+		}
+	}
+	delete(s.objects, k)
+	return obj, true, nil
+}
+
+func (s *memoryStorage) DeleteCollection(
+	ctx context.Context, deleteValidation registryrest.ValidateObjectFunc, options *metav1.DeleteOptions,
+	listOptions *registryrest.ListOptions) (runtime.Object, error) {
+	list, err := s.List(ctx, listOptions)
+	if err != nil {
+		return nil, err
+	}
+	if err := meta.EachListItem(list, func(obj runtime.Object) error {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		_, _, err = s.Delete(ctx, accessor.GetName(), deleteValidation, options)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *memoryStorage) Watch(ctx context.Context, _ *registryrest.ListOptions) (watch.Interface, error) {
+	// A real backend would emit events as objects change; tests and demos using this backend are expected
+	// to poll via List/Get rather than watch.
+	return watch.NewEmptyWatch(), nil
+}
+
+func (s *memoryStorage) ConvertToTable(
+	ctx context.Context, object runtime.Object, tableOptions runtime.Object) (*metav1.Table, error) {
+	return registryrest.NewDefaultTableConvertor(s.gr).ConvertToTable(ctx, object, tableOptions)
+}
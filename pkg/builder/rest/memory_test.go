@@ -0,0 +1,137 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	registryrest "k8s.io/apiserver/pkg/registry/rest"
+)
+
+func newTestMemoryStorage() *memoryStorage {
+	backend := NewInMemoryBackend()
+	storage, _, err := backend.NewStorage(testGVR, &fixture{}, nil)
+	if err != nil {
+		panic(err)
+	}
+	return storage.(*memoryStorage)
+}
+
+func withTestNamespace(ctx context.Context) context.Context {
+	return genericapirequest.WithNamespace(ctx, "default")
+}
+
+func TestMemoryStorageCreateGet(t *testing.T) {
+	s := newTestMemoryStorage()
+	ctx := withTestNamespace(context.Background())
+
+	obj := &fixture{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default"}, Value: "v1"}
+	if _, err := s.Create(ctx, obj, nil, &metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	got, err := s.Get(ctx, "a", &metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.(*fixture).Value != "v1" {
+		t.Errorf("Get returned Value %q, want %q", got.(*fixture).Value, "v1")
+	}
+
+	// Create must reject a duplicate name.
+	if _, err := s.Create(ctx, obj, nil, &metav1.CreateOptions{}); !apierrors.IsAlreadyExists(err) {
+		t.Errorf("second Create returned %v, want IsAlreadyExists", err)
+	}
+}
+
+func TestMemoryStorageGetMissing(t *testing.T) {
+	s := newTestMemoryStorage()
+	_, err := s.Get(withTestNamespace(context.Background()), "missing", &metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("Get on missing object returned %v, want IsNotFound", err)
+	}
+}
+
+func TestMemoryStorageUpdateCreatesWhenForced(t *testing.T) {
+	s := newTestMemoryStorage()
+	ctx := withTestNamespace(context.Background())
+
+	objInfo := registryrest.DefaultUpdatedObjectInfo(
+		&fixture{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default"}, Value: "v1"})
+	updated, created, err := s.Update(ctx, "a", objInfo, nil, nil, true, &metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if !created {
+		t.Error("Update with forceAllowCreate on a missing object returned created=false")
+	}
+	if updated.(*fixture).Value != "v1" {
+		t.Errorf("Update returned Value %q, want %q", updated.(*fixture).Value, "v1")
+	}
+
+	objInfo = registryrest.DefaultUpdatedObjectInfo(
+		&fixture{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default"}, Value: "v2"})
+	updated, created, err = s.Update(ctx, "a", objInfo, nil, nil, true, &metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if created {
+		t.Error("Update on an existing object returned created=true")
+	}
+	if updated.(*fixture).Value != "v2" {
+		t.Errorf("Update returned Value %q, want %q", updated.(*fixture).Value, "v2")
+	}
+}
+
+func TestMemoryStorageDeleteAndList(t *testing.T) {
+	s := newTestMemoryStorage()
+	ctx := withTestNamespace(context.Background())
+
+	for _, name := range []string{"a", "b"} {
+		obj := &fixture{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+		if _, err := s.Create(ctx, obj, nil, &metav1.CreateOptions{}); err != nil {
+			t.Fatalf("Create(%s): %v", name, err)
+		}
+	}
+
+	list, err := s.List(ctx, &registryrest.ListOptions{})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if got := len(list.(*fixtureList).Items); got != 2 {
+		t.Fatalf("List returned %d items, want 2", got)
+	}
+
+	if _, _, err := s.Delete(ctx, "a", nil, &metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(ctx, "a", &metav1.GetOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("Get after Delete returned %v, want IsNotFound", err)
+	}
+
+	list, err = s.List(ctx, &registryrest.ListOptions{})
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if got := len(list.(*fixtureList).Items); got != 1 {
+		t.Errorf("List after Delete returned %d items, want 1", got)
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"database/sql"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	registryrest "k8s.io/apiserver/pkg/registry/rest"
+	"sigs.k8s.io/apiserver-runtime/pkg/builder/resource"
+)
+
+// SQLRowMapper maps a resource's Go objects onto rows of a SQL table. NewSQLBackend is a template: it wires
+// the StandardStorage plumbing (validation hooks, table-scoped errors) and issues fixed queries over name,
+// namespace and data columns, but delegates the table name and the encoding of objects to and from rows to a
+// mapper supplied per GroupResource, since that is inherently specific to each resource's schema. The codec
+// passed to NewStorage is not used.
+type SQLRowMapper interface {
+	// TableName returns the SQL table objects of this resource are stored in.
+	TableName() string
+	// ToRow marshals obj into column values ToRow's caller can pass to a parameterized INSERT/UPDATE.
+	ToRow(obj runtime.Object) (name string, namespace string, data []byte, err error)
+	// FromRow unmarshals a stored row back into a runtime.Object of the resource's storage version.
+	FromRow(name, namespace string, data []byte) (runtime.Object, error)
+}
+
+// sqlBackend is a StorageBackend template for a SQL-backed store. It is intentionally thin: production use
+// will usually mean generating or hand-writing a SQLRowMapper per resource, and may want indexes, migrations
+// and transactions this template does not attempt to provide.
+type sqlBackend struct {
+	db     *sql.DB
+	mapper SQLRowMapper
+}
+
+// NewSQLBackend returns a StorageBackend template that stores objects as rows in db via mapper. The queries
+// it issues use "?" positional placeholders, so db must wrap a driver that accepts that syntax (e.g. "mysql",
+// "sqlite3") -- a driver that instead requires numbered placeholders (e.g. "postgres"/"pgx") will fail every
+// call.
+//
+// This is a starting point, not a drop-in replacement for the etcd backend: callers are expected to create
+// the underlying table out of band and to extend sqlStorage (or write their own StorageBackend) for
+// resources needing label selectors, field selectors, watch support, or a different placeholder syntax,
+// beyond what this template provides.
+func NewSQLBackend(db *sql.DB, mapper SQLRowMapper) StorageBackend {
+	return &sqlBackend{db: db, mapper: mapper}
+}
+
+func (b *sqlBackend) NewStorage(
+	gvr schema.GroupVersionResource, obj resource.Object, codec runtime.Codec,
+) (registryrest.StandardStorage, DestroyFunc, error) {
+	if b.mapper == nil {
+		return nil, nil, fmt.Errorf("sql backend for %s requires a SQLRowMapper", gvr.GroupResource())
+	}
+	s := &sqlStorage{
+		gr:          gvr.GroupResource(),
+		db:          b.db,
+		mapper:      b.mapper,
+		newFunc:     obj.New,
+		newListFunc: obj.NewList,
+	}
+	return s, func() {}, nil
+}
@@ -0,0 +1,218 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	genericapirequest "k8s.io/apiserver/pkg/endpoints/request"
+	registryrest "k8s.io/apiserver/pkg/registry/rest"
+)
+
+// sqlStorage implements registryrest.StandardStorage with rows of a single SQL table, one row per object,
+// using mapper to translate between the table and the resource's Go type.
+type sqlStorage struct {
+	gr          schema.GroupResource
+	db          *sql.DB
+	mapper      SQLRowMapper
+	newFunc     func() runtime.Object
+	newListFunc func() runtime.Object
+}
+
+func (s *sqlStorage) New() runtime.Object { return s.newFunc() }
+
+func (s *sqlStorage) NewList() runtime.Object { return s.newListFunc() }
+
+func (s *sqlStorage) Destroy() {}
+
+func namespaceOf(ctx context.Context) string {
+	ns, _ := genericapirequest.NamespaceFrom(ctx)
+	return ns
+}
+
+func (s *sqlStorage) Get(
+	ctx context.Context, name string, _ *metav1.GetOptions) (runtime.Object, error) {
+	row := s.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT data FROM %s WHERE namespace = ? AND name = ?", s.mapper.TableName()),
+		namespaceOf(ctx), name)
+	var data []byte
+	if err := row.Scan(&data); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, apierrors.NewNotFound(s.gr, name)
+		}
+		return nil, err
+	}
+	return s.mapper.FromRow(name, namespaceOf(ctx), data)
+}
+
+func (s *sqlStorage) List(ctx context.Context, _ *registryrest.ListOptions) (runtime.Object, error) {
+	rows, err := s.db.QueryContext(ctx,
+		fmt.Sprintf("SELECT name, namespace, data FROM %s WHERE namespace = ?", s.mapper.TableName()),
+		namespaceOf(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []runtime.Object
+	for rows.Next() {
+		var name, ns string
+		var data []byte
+		if err := rows.Scan(&name, &ns, &data); err != nil {
+			return nil, err
+		}
+		obj, err := s.mapper.FromRow(name, ns, data)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, obj)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	list := s.newListFunc()
+	if err := meta.SetList(list, items); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *sqlStorage) Create(
+	ctx context.Context, obj runtime.Object, createValidation registryrest.ValidateObjectFunc,
+	_ *metav1.CreateOptions) (runtime.Object, error) {
+	if createValidation != nil {
+		if err := createValidation(ctx, obj); err != nil {
+			return nil, err
+		}
+	}
+	name, ns, data, err := s.mapper.ToRow(obj)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.db.ExecContext(ctx,
+		fmt.Sprintf("INSERT INTO %s (name, namespace, data) VALUES (?, ?, ?)", s.mapper.TableName()),
+		name, ns, data)
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (s *sqlStorage) Update(
+	ctx context.Context, name string, objInfo registryrest.UpdatedObjectInfo,
+	createValidation registryrest.ValidateObjectFunc, updateValidation registryrest.ValidateObjectUpdateFunc,
+	forceAllowCreate bool, options *metav1.UpdateOptions) (runtime.Object, bool, error) {
+	old, err := s.Get(ctx, name, &metav1.GetOptions{})
+	created := false
+	if err != nil {
+		if !apierrors.IsNotFound(err) || !forceAllowCreate {
+			return nil, false, err
+		}
+		old = nil
+		created = true
+	}
+	updated, err := objInfo.UpdatedObject(ctx, old)
+	if err != nil {
+		return nil, false, err
+	}
+	if created {
+		if createValidation != nil {
+			if err := createValidation(ctx, updated); err != nil {
+				return nil, false, err
+			}
+		}
+		createdObj, err := s.Create(ctx, updated, nil, &metav1.CreateOptions{})
+		return createdObj, true, err
+	}
+	if updateValidation != nil {
+		if err := updateValidation(ctx, updated, old); err != nil {
+			return nil, false, err
+		}
+	}
+	_, ns, data, err := s.mapper.ToRow(updated)
+	if err != nil {
+		return nil, false, err
+	}
+	_, err = s.db.ExecContext(ctx,
+		fmt.Sprintf("UPDATE %s SET data = ? WHERE namespace = ? AND name = ?", s.mapper.TableName()),
+		data, ns, name)
+	if err != nil {
+		return nil, false, err
+	}
+	return updated, false, nil
+}
+
+func (s *sqlStorage) Delete(
+	ctx context.Context, name string, deleteValidation registryrest.ValidateObjectFunc,
+	_ *metav1.DeleteOptions) (runtime.Object, bool, error) {
+	obj, err := s.Get(ctx, name, &metav1.GetOptions{})
+	if err != nil {
+		return nil, false, err
+	}
+	if deleteValidation != nil {
+		if err := deleteValidation(ctx, obj); err != nil {
+			return nil, false, err
+		}
+	}
+	_, err = s.db.ExecContext(ctx,
+		fmt.Sprintf("DELETE FROM %s WHERE namespace = ? AND name = ?", s.mapper.TableName()),
+		namespaceOf(ctx), name)
+	if err != nil {
+		return nil, false, err
+	}
+	return obj, true, nil
+}
+
+func (s *sqlStorage) DeleteCollection(
+	ctx context.Context, deleteValidation registryrest.ValidateObjectFunc, options *metav1.DeleteOptions,
+	listOptions *registryrest.ListOptions) (runtime.Object, error) {
+	list, err := s.List(ctx, listOptions)
+	if err != nil {
+		return nil, err
+	}
+	if err := meta.EachListItem(list, func(obj runtime.Object) error {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		_, _, err = s.Delete(ctx, accessor.GetName(), deleteValidation, options)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *sqlStorage) Watch(ctx context.Context, _ *registryrest.ListOptions) (watch.Interface, error) {
+	// This template does not implement change notification (e.g. via a trigger/outbox table or LISTEN/NOTIFY)
+	// -- callers needing watch support should extend this backend for their SQL engine of choice.
+	return watch.NewEmptyWatch(), nil
+}
+
+func (s *sqlStorage) ConvertToTable(
+	ctx context.Context, object runtime.Object, tableOptions runtime.Object) (*metav1.Table, error) {
+	return registryrest.NewDefaultTableConvertor(s.gr).ConvertToTable(ctx, object, tableOptions)
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rest
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var testGVR = schema.GroupVersionResource{Group: "tests.example.com", Version: "v1", Resource: "fixtures"}
+
+// fixture is the minimal resource.Object implementation the tests in this package register storage for.
+type fixture struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Value             string `json:"value,omitempty"`
+}
+
+func (f *fixture) DeepCopyObject() runtime.Object {
+	cp := *f
+	cp.ObjectMeta = *f.ObjectMeta.DeepCopy()
+	return &cp
+}
+
+func (f *fixture) GetObjectKind() schema.ObjectKind { return &f.TypeMeta }
+
+func (f *fixture) New() runtime.Object { return &fixture{} }
+
+func (f *fixture) NewList() runtime.Object { return &fixtureList{} }
+
+func (f *fixture) NamespaceScoped() bool { return true }
+
+func (f *fixture) IsStorageVersion() bool { return true }
+
+func (f *fixture) GetGroupVersionResource() schema.GroupVersionResource { return testGVR }
+
+// fixtureList is fixture's list type.
+type fixtureList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []fixture `json:"items"`
+}
+
+func (l *fixtureList) DeepCopyObject() runtime.Object {
+	cp := *l
+	cp.Items = make([]fixture, len(l.Items))
+	for i := range l.Items {
+		cp.Items[i] = *l.Items[i].DeepCopyObject().(*fixture)
+	}
+	return &cp
+}
+
+func (l *fixtureList) GetObjectKind() schema.ObjectKind { return &l.TypeMeta }